@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyConfig describes one per-device API key and its quotas, as loaded
+// from an auth config file or an auth provider URL.
+type APIKeyConfig struct {
+	UID               string `json:"uid"`
+	Key               string `json:"key"`
+	BytesPerDay       int64  `json:"bytes_per_day"`
+	RequestsPerMinute int    `json:"requests_per_minute"`
+}
+
+// AuthConfig is the shape of the auth config file / auth provider
+// response: a fleet-wide admin key plus one entry per device.
+type AuthConfig struct {
+	AdminKey                 string         `json:"admin_key"`
+	DefaultBytesPerDay       int64          `json:"default_bytes_per_day"`
+	DefaultRequestsPerMinute int            `json:"default_requests_per_minute"`
+	Keys                     []APIKeyConfig `json:"keys"`
+}
+
+const (
+	defaultBytesPerDay       = 500 * 1024 * 1024
+	defaultRequestsPerMinute = 60
+)
+
+// loadAuthConfig reads an AuthConfig from a local JSON file.
+func loadAuthConfig(path string) (*AuthConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth config file: %v", err)
+	}
+	return parseAuthConfig(data)
+}
+
+// fetchAuthConfig reads an AuthConfig from a remote auth provider URL.
+func fetchAuthConfig(url string) (*AuthConfig, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch auth config: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth provider returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth provider response: %v", err)
+	}
+	return parseAuthConfig(data)
+}
+
+func parseAuthConfig(data []byte) (*AuthConfig, error) {
+	var cfg AuthConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse auth config: %v", err)
+	}
+	return &cfg, nil
+}
+
+// tokenBucket enforces a requests-per-minute limit, refilling continuously
+// rather than resetting in a hard one-minute window.
+type tokenBucket struct {
+	mu           sync.Mutex
+	capacity     float64
+	tokens       float64
+	refillPerSec float64
+	last         time.Time
+}
+
+func newTokenBucket(requestsPerMinute int) *tokenBucket {
+	capacity := float64(requestsPerMinute)
+	return &tokenBucket{
+		capacity:     capacity,
+		tokens:       capacity,
+		refillPerSec: capacity / 60,
+		last:         time.Now(),
+	}
+}
+
+// allow reports whether a request may proceed now, consuming one token if
+// so. If not, it also returns how long the caller should wait before
+// retrying.
+func (b *tokenBucket) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.last
+	if n := time.Now(); n.After(now) {
+		now = n
+	}
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.refillPerSec)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	deficit := 1 - b.tokens
+	wait := time.Duration(deficit / b.refillPerSec * float64(time.Second))
+	return false, wait
+}
+
+// apiKey tracks the quotas and live usage for one device's API key.
+type apiKey struct {
+	uid               string
+	key               string
+	bytesPerDay       int64
+	requestsPerMinute int
+	bucket            *tokenBucket
+
+	mu             sync.Mutex
+	bytesUsedToday int64
+	dayStart       time.Time
+	requestCount   int64
+}
+
+// allowBytes reports whether consuming n more bytes today would stay
+// within the key's daily quota, accounting for it if so.
+func (k *apiKey) allowBytes(n int64) (bool, time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(k.dayStart) > 24*time.Hour {
+		k.dayStart = now
+		k.bytesUsedToday = 0
+	}
+
+	if k.bytesUsedToday+n > k.bytesPerDay {
+		return false, k.dayStart.Add(24 * time.Hour).Sub(now)
+	}
+
+	k.bytesUsedToday += n
+	k.requestCount++
+	return true, 0
+}
+
+func (k *apiKey) usage() keyUsage {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return keyUsage{
+		UID:               k.uid,
+		BytesPerDay:       k.bytesPerDay,
+		RequestsPerMinute: k.requestsPerMinute,
+		BytesUsedToday:    k.bytesUsedToday,
+		RequestCount:      k.requestCount,
+	}
+}
+
+// keyUsage is the per-key JSON payload returned by GET /admin/keys.
+type keyUsage struct {
+	UID               string `json:"uid"`
+	BytesPerDay       int64  `json:"bytes_per_day"`
+	RequestsPerMinute int    `json:"requests_per_minute"`
+	BytesUsedToday    int64  `json:"bytes_used_today"`
+	RequestCount      int64  `json:"request_count"`
+}
+
+// auth is the active authProvider, or nil if no auth config/provider was
+// supplied at startup.
+var auth *authProvider
+
+// authProvider authenticates requests against a set of per-device API
+// keys and enforces their rate and quota limits. A nil *authProvider
+// means auth is disabled, matching how AGFS/storage are optional.
+type authProvider struct {
+	mu       sync.RWMutex
+	byKey    map[string]*apiKey
+	adminKey string
+
+	defaultBytesPerDay       int64
+	defaultRequestsPerMinute int
+}
+
+func newAuthProvider(cfg AuthConfig) *authProvider {
+	p := &authProvider{
+		byKey:                    make(map[string]*apiKey),
+		adminKey:                 cfg.AdminKey,
+		defaultBytesPerDay:       cfg.DefaultBytesPerDay,
+		defaultRequestsPerMinute: cfg.DefaultRequestsPerMinute,
+	}
+	if p.defaultBytesPerDay <= 0 {
+		p.defaultBytesPerDay = defaultBytesPerDay
+	}
+	if p.defaultRequestsPerMinute <= 0 {
+		p.defaultRequestsPerMinute = defaultRequestsPerMinute
+	}
+
+	for _, k := range cfg.Keys {
+		p.add(k)
+	}
+	return p
+}
+
+func (p *authProvider) add(cfg APIKeyConfig) *apiKey {
+	bytesPerDay := cfg.BytesPerDay
+	if bytesPerDay <= 0 {
+		bytesPerDay = p.defaultBytesPerDay
+	}
+	requestsPerMinute := cfg.RequestsPerMinute
+	if requestsPerMinute <= 0 {
+		requestsPerMinute = p.defaultRequestsPerMinute
+	}
+
+	k := &apiKey{
+		uid:               cfg.UID,
+		key:               cfg.Key,
+		bytesPerDay:       bytesPerDay,
+		requestsPerMinute: requestsPerMinute,
+		bucket:            newTokenBucket(requestsPerMinute),
+		dayStart:          time.Now(),
+	}
+
+	p.mu.Lock()
+	p.byKey[cfg.Key] = k
+	p.mu.Unlock()
+
+	return k
+}
+
+// rotate replaces any existing key for uid with a newly generated one and
+// returns it.
+func (p *authProvider) rotate(uid string) (*apiKey, error) {
+	newKey, err := randomID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %v", err)
+	}
+
+	p.mu.Lock()
+	for k, existing := range p.byKey {
+		if existing.uid == uid {
+			delete(p.byKey, k)
+		}
+	}
+	p.mu.Unlock()
+
+	return p.add(APIKeyConfig{UID: uid, Key: newKey}), nil
+}
+
+func (p *authProvider) lookup(key string) (*apiKey, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	k, ok := p.byKey[key]
+	return k, ok
+}
+
+func (p *authProvider) usageReport() []keyUsage {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	report := make([]keyUsage, 0, len(p.byKey))
+	for _, k := range p.byKey {
+		report = append(report, k.usage())
+	}
+	return report
+}
+
+// authUIDKeyType is the context key requireAuth uses to pass the
+// authenticated caller's uid down to handlers that need to check
+// ownership of a path-addressed resource. A uid isn't always available
+// as a query parameter: /sessions/{uid} carries it in the path, and
+// /files/{id} doesn't carry it at all, only the upload's own uid that
+// the handler looks up itself.
+type authUIDKeyType struct{}
+
+var authUIDKey authUIDKeyType
+
+// authUID returns the uid of the API key that authenticated r. ok is
+// false when auth is disabled, in which case no ownership check should
+// be enforced.
+func authUID(r *http.Request) (uid string, ok bool) {
+	uid, ok = r.Context().Value(authUIDKey).(string)
+	return uid, ok
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	return strings.TrimSpace(strings.TrimPrefix(h, "Bearer"))
+}
+
+func retryAfterHeader(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+// requireAuth wraps an endpoint with per-device API key authentication
+// and the key's request-rate and daily byte quotas. If no auth provider
+// is configured, the server runs open, as it always has.
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil {
+			next(w, r)
+			return
+		}
+
+		token := bearerToken(r)
+		key, ok := auth.lookup(token)
+		if !ok {
+			http.Error(w, "invalid or missing API key", http.StatusUnauthorized)
+			return
+		}
+
+		if uid := r.URL.Query().Get("uid"); uid != "" && uid != key.uid {
+			http.Error(w, "API key does not match uid", http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), authUIDKey, key.uid))
+
+		if allowed, wait := key.bucket.allow(); !allowed {
+			retryAfterHeader(w, wait)
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		// Content-Length isn't always set on chunked uploads; fall back to
+		// the configured max as a conservative estimate for the quota.
+		estimatedBytes := r.ContentLength
+		if estimatedBytes < 0 {
+			estimatedBytes = maxUploadBytes
+		}
+		if allowed, wait := key.allowBytes(estimatedBytes); !allowed {
+			retryAfterHeader(w, wait)
+			http.Error(w, "daily byte quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireAdmin gates the key-management endpoint behind the fleet-wide
+// admin key.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil {
+			http.Error(w, "auth is not configured", http.StatusNotFound)
+			return
+		}
+
+		if token := bearerToken(r); token == "" || token != auth.adminKey {
+			http.Error(w, "invalid admin key", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// adminKeysHandler lets an operator rotate a device's API key (POST) or
+// view per-key usage stats (GET).
+func adminKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(auth.usageReport())
+	case http.MethodPost:
+		var req struct {
+			UID string `json:"uid"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UID == "" {
+			http.Error(w, "request body must be JSON with a non-empty uid", http.StatusBadRequest)
+			return
+		}
+
+		key, err := auth.rotate(req.UID)
+		if err != nil {
+			log.Printf("Failed to rotate API key for uid %s: %v", req.UID, err)
+			http.Error(w, "failed to rotate key", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			UID string `json:"uid"`
+			Key string `json:"key"`
+		}{UID: key.uid, Key: key.key})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowsUpToCapacityThenBlocks(t *testing.T) {
+	b := newTokenBucket(60) // 1 token/sec, capacity 60
+	b.tokens = 2
+	b.last = time.Now()
+
+	if ok, wait := b.allow(); !ok || wait != 0 {
+		t.Fatalf("first request: got allowed=%v wait=%v, want allowed=true wait=0", ok, wait)
+	}
+	if ok, wait := b.allow(); !ok || wait != 0 {
+		t.Fatalf("second request: got allowed=%v wait=%v, want allowed=true wait=0", ok, wait)
+	}
+	ok, wait := b.allow()
+	if ok {
+		t.Fatalf("third request: got allowed=true, want rate limited")
+	}
+	if wait <= 0 {
+		t.Fatalf("third request: got wait=%v, want a positive retry-after", wait)
+	}
+}
+
+func TestTokenBucketRefillsOverTime(t *testing.T) {
+	b := newTokenBucket(60) // 1 token/sec
+	b.tokens = 0
+	b.last = time.Now().Add(-2 * time.Second)
+
+	if ok, _ := b.allow(); !ok {
+		t.Fatalf("expected a refilled token to be available after 2s of elapsed time")
+	}
+}
+
+func TestTokenBucketNeverExceedsCapacity(t *testing.T) {
+	b := newTokenBucket(60)
+	b.tokens = 60
+	b.last = time.Now().Add(-time.Hour)
+
+	b.allow()
+	if b.tokens > 59 {
+		t.Fatalf("tokens = %v, want capped at capacity minus the token just consumed", b.tokens)
+	}
+}
+
+func TestAPIKeyAllowBytesWithinQuota(t *testing.T) {
+	k := &apiKey{bytesPerDay: 1000, dayStart: time.Now()}
+
+	ok, _ := k.allowBytes(400)
+	if !ok {
+		t.Fatalf("expected 400 of 1000 bytes to be allowed")
+	}
+	ok, _ = k.allowBytes(400)
+	if !ok {
+		t.Fatalf("expected a second 400 bytes (800 of 1000) to be allowed")
+	}
+	if k.bytesUsedToday != 800 {
+		t.Fatalf("bytesUsedToday = %d, want 800", k.bytesUsedToday)
+	}
+}
+
+func TestAPIKeyAllowBytesOverQuota(t *testing.T) {
+	k := &apiKey{bytesPerDay: 1000, dayStart: time.Now()}
+
+	ok, wait := k.allowBytes(1500)
+	if ok {
+		t.Fatalf("expected a request over the daily quota to be rejected")
+	}
+	if wait <= 0 {
+		t.Fatalf("got wait=%v, want a positive retry-after until the quota resets", wait)
+	}
+	if k.bytesUsedToday != 0 {
+		t.Fatalf("bytesUsedToday = %d, want unchanged at 0 after a rejected request", k.bytesUsedToday)
+	}
+}
+
+func TestAPIKeyAllowBytesResetsAfterADay(t *testing.T) {
+	k := &apiKey{bytesPerDay: 1000, dayStart: time.Now().Add(-25 * time.Hour), bytesUsedToday: 999}
+
+	ok, _ := k.allowBytes(500)
+	if !ok {
+		t.Fatalf("expected quota to have reset after 25 hours")
+	}
+	if k.bytesUsedToday != 500 {
+		t.Fatalf("bytesUsedToday = %d, want 500 after reset", k.bytesUsedToday)
+	}
+}
+
+func TestAuthProviderRotateReplacesExistingKey(t *testing.T) {
+	p := newAuthProvider(AuthConfig{Keys: []APIKeyConfig{{UID: "uid1", Key: "old-key"}}})
+
+	newKey, err := p.rotate("uid1")
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if newKey.key == "old-key" {
+		t.Fatalf("rotate returned the same key value")
+	}
+	if _, ok := p.lookup("old-key"); ok {
+		t.Fatalf("old key should no longer be valid after rotation")
+	}
+	if _, ok := p.lookup(newKey.key); !ok {
+		t.Fatalf("new key should be valid immediately after rotation")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// StorageConfig selects and configures the storage backends used by
+// handlePostAudio. Order lists the backends to chain, primary first
+// (e.g. []string{"s3", "local"} ships straight to S3 and only falls back
+// to local disk if that upload fails).
+type StorageConfig struct {
+	Order         []string `json:"order"`
+	LocalDir      string   `json:"local_dir"`
+	AGFSAPIURL    string   `json:"agfs_api_url"`
+	AGFSUploadDir string   `json:"agfs_upload_path"`
+	S3            S3Config `json:"s3"`
+}
+
+// loadStorageConfig reads a JSON config file produced with -config. Flags
+// passed on the command line take precedence over values loaded here and
+// are applied by the caller after this returns.
+func loadStorageConfig(path string) (*StorageConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var cfg StorageConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	return &cfg, nil
+}
+
+// buildStorage turns a StorageConfig into the Storage implementation used
+// by handlePostAudio, chaining backends in the configured order.
+func buildStorage(cfg StorageConfig) (Storage, error) {
+	if len(cfg.Order) == 0 {
+		cfg.Order = []string{"local"}
+	}
+
+	var backends []Storage
+	for _, name := range cfg.Order {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "local":
+			dir := cfg.LocalDir
+			if dir == "" {
+				dir = "./audio_files"
+			}
+			backends = append(backends, NewLocalStorage(dir))
+		case "agfs":
+			if cfg.AGFSAPIURL == "" {
+				return nil, fmt.Errorf("storage order includes \"agfs\" but agfs-api-url is not set")
+			}
+			backends = append(backends, NewAGFSStorage(cfg.AGFSAPIURL, cfg.AGFSUploadDir))
+		case "s3":
+			s3Storage, err := NewS3Storage(cfg.S3)
+			if err != nil {
+				return nil, err
+			}
+			backends = append(backends, s3Storage)
+		default:
+			return nil, fmt.Errorf("unknown storage backend %q", name)
+		}
+	}
+
+	if len(backends) == 1 {
+		return backends[0], nil
+	}
+	return NewChainStorage(backends...), nil
+}
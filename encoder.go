@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	lame "github.com/viert/go-lame"
+	opus "gopkg.in/hraban/opus.v2"
+)
+
+// AudioFileMetadata is the sidecar JSON written next to every encoded
+// recording so downstream tools don't have to re-parse the container
+// header to know what's inside it.
+type AudioFileMetadata struct {
+	SampleRate int   `json:"sample_rate"`
+	Channels   int   `json:"channels"`
+	Frames     int   `json:"frames"`
+	Bytes      int   `json:"bytes"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// Encoder turns raw 16 kHz/16-bit little-endian PCM into a stored
+// container format, streaming output as input arrives rather than
+// requiring the whole recording up front. w must be seekable so formats
+// with a fixed-size header (WAV) can patch it in place once the true
+// byte count is known, instead of buffering the whole recording first.
+type Encoder interface {
+	// Extension returns the file extension (without the dot) this encoder
+	// produces, e.g. "wav", "mp3", "opus".
+	Extension() string
+	// Encode reads PCM16LE samples from r, writes the encoded container to
+	// w, and returns metadata describing the decoded input.
+	Encode(w io.WriteSeeker, r io.Reader, sampleRate, channels int) (AudioFileMetadata, error)
+}
+
+func pcmMetadata(pcmBytes int, sampleRate, channels int) AudioFileMetadata {
+	bytesPerFrame := channels * bitsPerSample / 8
+	frames := 0
+	if bytesPerFrame > 0 {
+		frames = pcmBytes / bytesPerFrame
+	}
+	durationMs := int64(0)
+	if sampleRate > 0 {
+		durationMs = int64(frames) * 1000 / int64(sampleRate)
+	}
+	return AudioFileMetadata{
+		SampleRate: sampleRate,
+		Channels:   channels,
+		Frames:     frames,
+		Bytes:      pcmBytes,
+		DurationMs: durationMs,
+	}
+}
+
+// WAVEncoder wraps the raw PCM in a WAV container, preserving the
+// server's original on-disk format.
+type WAVEncoder struct{}
+
+func (WAVEncoder) Extension() string { return "wav" }
+
+// Encode streams r straight to disk: it writes a placeholder 44-byte WAV
+// header, copies the PCM data through, then seeks back and patches the
+// RIFF/data sizes once the true byte count is known. This keeps memory
+// use bounded regardless of recording length.
+func (WAVEncoder) Encode(w io.WriteSeeker, r io.Reader, sampleRate, channels int) (AudioFileMetadata, error) {
+	if _, err := w.Write(createWAVHeader(0)); err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to write WAV header: %v", err)
+	}
+
+	written, err := io.Copy(w, r)
+	if err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to write PCM data: %w", err)
+	}
+
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to seek to patch WAV header: %v", err)
+	}
+	if _, err := w.Write(createWAVHeader(int(written))); err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to patch WAV header: %v", err)
+	}
+
+	return pcmMetadata(int(written), sampleRate, channels), nil
+}
+
+// MP3Encoder transcodes PCM16LE to MP3 using go-lame.
+type MP3Encoder struct{}
+
+func (MP3Encoder) Extension() string { return "mp3" }
+
+func (MP3Encoder) Encode(w io.WriteSeeker, r io.Reader, sampleRate, channels int) (AudioFileMetadata, error) {
+	enc, err := lame.NewEncoder(w)
+	if err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to create MP3 encoder: %v", err)
+	}
+	enc.SetInSamplerate(sampleRate)
+	enc.SetNumChannels(channels)
+	enc.SetMode(lame.MODE_MONO)
+	enc.SetQuality(5)
+	enc.InitParams()
+	defer enc.Close()
+
+	pcmBytes, err := io.Copy(enc, r)
+	if err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to encode MP3: %w", err)
+	}
+
+	return pcmMetadata(int(pcmBytes), sampleRate, channels), nil
+}
+
+// OpusEncoder transcodes PCM16LE to Opus, wrapped in an Ogg container, as
+// produced by the Omi broadcast example.
+type OpusEncoder struct{}
+
+func (OpusEncoder) Extension() string { return "opus" }
+
+// opusFrameSamples is the frame size (per channel) the Opus encoder is fed
+// on each call; 20ms at 16kHz.
+const opusFrameSamples = sampleRate / 50
+
+func (OpusEncoder) Encode(w io.WriteSeeker, r io.Reader, sampleRate, channels int) (AudioFileMetadata, error) {
+	enc, err := opus.NewEncoder(sampleRate, channels, opus.AppVoIP)
+	if err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to create Opus encoder: %v", err)
+	}
+
+	ogg := newOggOpusWriter(w, sampleRate, channels)
+	if err := ogg.WriteHeaders(); err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to write Ogg headers: %v", err)
+	}
+
+	frameBytes := opusFrameSamples * channels * (bitsPerSample / 8)
+	buf := make([]byte, frameBytes)
+	pcmBytes := 0
+	out := make([]byte, 4000)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			frame := buf
+			if n < frameBytes {
+				// libopus only accepts a fixed set of frame durations, so
+				// the final, short read has to be padded with silence up
+				// to a full frame rather than encoded as-is. The padding
+				// itself isn't real audio, so it's excluded below from
+				// the byte/duration count in pcmMetadata.
+				frame = make([]byte, frameBytes)
+				copy(frame, buf[:n])
+			}
+			pcm := bytesToPCM16(frame)
+			encoded, encErr := enc.Encode(pcm, out)
+			if encErr != nil {
+				return AudioFileMetadata{}, fmt.Errorf("failed to encode Opus frame: %v", encErr)
+			}
+			if err := ogg.WritePacket(out[:encoded]); err != nil {
+				return AudioFileMetadata{}, fmt.Errorf("failed to write Opus packet: %v", err)
+			}
+			pcmBytes += n
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return AudioFileMetadata{}, fmt.Errorf("failed to read PCM input: %w", err)
+		}
+	}
+
+	if err := ogg.Close(); err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to finalize Ogg container: %v", err)
+	}
+
+	return pcmMetadata(pcmBytes, sampleRate, channels), nil
+}
+
+// buildEncoder resolves the --encode flag to an Encoder implementation.
+func buildEncoder(name string) (Encoder, error) {
+	switch name {
+	case "", "wav":
+		return WAVEncoder{}, nil
+	case "mp3":
+		return MP3Encoder{}, nil
+	case "opus":
+		return OpusEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown encoder %q (want wav, mp3, or opus)", name)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpusEncoderExcludesPaddingFromMetadata(t *testing.T) {
+	channels := 1
+	frameBytes := opusFrameSamples * channels * (bitsPerSample / 8)
+	totalBytes := frameBytes*2 + 100 // two full frames plus a non-frame-aligned remainder
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.opus"))
+	if err != nil {
+		t.Fatalf("create output file: %v", err)
+	}
+	defer out.Close()
+
+	meta, err := OpusEncoder{}.Encode(out, bytes.NewReader(make([]byte, totalBytes)), sampleRate, channels)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	want := pcmMetadata(totalBytes, sampleRate, channels)
+	if meta != want {
+		t.Fatalf("metadata = %+v, want %+v (silence padding on the final frame must not be counted)", meta, want)
+	}
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -17,13 +23,31 @@ const (
 	numChannels   = 1 // Mono audio
 	sampleRate    = 16000
 	bitsPerSample = 16 // 16 bits per sample
+
+	// defaultMaxUploadBytes bounds a single /audio upload at ~1 hour of
+	// raw 16kHz/16-bit mono PCM.
+	defaultMaxUploadBytes = 120 * 1024 * 1024
 )
 
 var (
-	agfsClient *agfs.Client
-	agfsUploadPath string
+	storage        Storage
+	audioEncoder   Encoder = WAVEncoder{}
+	maxUploadBytes int64   = defaultMaxUploadBytes
 )
 
+// contentTypeForExt returns the MIME type to report to storage backends
+// for a given encoded audio file extension.
+func contentTypeForExt(ext string) string {
+	switch ext {
+	case "mp3":
+		return "audio/mpeg"
+	case "opus":
+		return "audio/ogg"
+	default:
+		return "audio/wav"
+	}
+}
+
 // CreateWAVHeader generates a WAV header for the given data length
 func createWAVHeader(dataLength int) []byte {
 	byteRate := sampleRate * numChannels * bitsPerSample / 8
@@ -49,148 +73,189 @@ func createWAVHeader(dataLength int) []byte {
 	return header
 }
 
-func saveFileLocally(storageDir string, fileName string, tempFilePath string) error {
-	// Create storage directory if it doesn't exist
-	if err := os.MkdirAll(storageDir, 0755); err != nil {
-		return fmt.Errorf("failed to create storage directory: %v", err)
-	}
+func handlePostAudio(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sampleRateParam := query.Get("sample_rate")
+	uid := query.Get("uid")
 
-	// Define destination path
-	destPath := filepath.Join(storageDir, fileName)
+	log.Printf("Received request from uid: %s", uid)
+	log.Printf("Requested sample rate: %s", sampleRateParam)
 
-	// Copy file from temp location to storage directory
-	srcFile, err := os.Open(tempFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to open source file: %v", err)
+	defer r.Body.Close()
+
+	// Stream the body straight into the encode/storage pipeline instead of
+	// buffering it in memory, via a reader that caps how much we'll accept
+	// and a tee that computes an MD5 checksum as the bytes go by.
+	limited := http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	hasher := md5.New()
+	tee := io.TeeReader(limited, hasher)
+
+	var (
+		filename string
+		err      error
+	)
+	if uid != "" {
+		err = sessions.addChunk(r.Context(), uid, tee)
+	} else {
+		filename, err = processRecording(r.Context(), tee)
 	}
-	defer srcFile.Close()
 
-	destFile, err := os.Create(destPath)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %v", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body exceeds max-upload-bytes limit", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Failed to process recording for uid %s: %v", uid, err)
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
 	}
-	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, srcFile); err != nil {
-		return fmt.Errorf("failed to copy file: %v", err)
+	checksum := base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+	if want := r.Header.Get("Content-MD5"); want != "" && want != checksum {
+		log.Printf("Content-MD5 mismatch for uid %s: got %s, expected %s", uid, checksum, want)
+		if uid != "" {
+			// The chunk is now part of uid's rolling session playlist
+			// rather than a single standalone object, so there's nothing
+			// clean to delete here; the corrupt segment is left in place
+			// and only surfaced via this error response.
+			log.Printf("Not cleaning up session chunk for uid %s after Content-MD5 mismatch: already appended to the session playlist", uid)
+		} else {
+			cleanupRecording(r.Context(), filename)
+		}
+		http.Error(w, "Content-MD5 mismatch", http.StatusBadRequest)
+		return
 	}
+	w.Header().Set("Content-MD5", checksum)
 
-	log.Printf("File %s saved to local storage directory %s successfully.", fileName, storageDir)
-	return nil
+	w.WriteHeader(http.StatusOK)
+	if uid != "" {
+		w.Write([]byte("Audio bytes received and added to session"))
+		return
+	}
+	w.Write([]byte(fmt.Sprintf("Audio bytes received and saved as %s", filename)))
 }
 
-func uploadToAGFS(filePath string, fileName string) error {
-	if agfsClient == nil {
-		return fmt.Errorf("AGFS client not initialized")
-	}
+// processRecording encodes raw PCM16LE audio read from pcm, uploads the
+// result through the configured storage chain, and writes its metadata
+// sidecar alongside it. It's shared by the single-shot /audio handler and
+// the tus finalize step, since both end up with a complete recording to
+// encode and store.
+func processRecording(ctx context.Context, pcm io.Reader) (string, error) {
+	currentTime := time.Now()
+	baseName := fmt.Sprintf("%02d_%02d_%04d_%02d_%02d_%02d",
+		currentTime.Day(),
+		currentTime.Month(),
+		currentTime.Year(),
+		currentTime.Hour(),
+		currentTime.Minute(),
+		currentTime.Second())
+	filename := baseName + "." + audioEncoder.Extension()
 
-	// Read file content
-	fileData, err := os.ReadFile(filePath)
+	audioMeta, err := encodeAndStore(ctx, filename, pcm)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %v", err)
-	}
-
-	// Construct full path with upload path prefix
-	fullPath := fileName
-	if agfsUploadPath != "" {
-		fullPath = filepath.Join(agfsUploadPath, fileName)
+		return "", err
 	}
 
-	// Upload to AGFS
-	_, err = agfsClient.Write(fullPath, fileData)
-	if err != nil {
-		return fmt.Errorf("failed to upload to AGFS: %v", err)
+	if err := writeMetadataSidecar(ctx, baseName+".json", audioMeta); err != nil {
+		log.Printf("Failed to save metadata sidecar: %v", err)
 	}
 
-	log.Printf("File uploaded to AGFS at path: %s", fullPath)
-	return nil
+	return filename, nil
 }
 
-func handlePostAudio(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()
-	sampleRateParam := query.Get("sample_rate")
-	uid := query.Get("uid")
-
-	log.Printf("Received request from uid: %s", uid)
-	log.Printf("Requested sample rate: %s", sampleRateParam)
+// encodeAndStore runs pcm through the configured Encoder and uploads the
+// result to the configured storage chain under the given name, returning
+// the decoded audio's metadata.
+func encodeAndStore(ctx context.Context, name string, pcm io.Reader) (AudioFileMetadata, error) {
+	tempFilePath := filepath.Join(os.TempDir(), filepath.Base(name))
 
-	body, err := io.ReadAll(r.Body)
+	tempFile, err := os.Create(tempFilePath)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
+		return AudioFileMetadata{}, fmt.Errorf("failed to create temp file: %v", err)
 	}
-	defer r.Body.Close()
-
-	currentTime := time.Now()
-	filename := fmt.Sprintf("%02d_%02d_%04d_%02d_%02d_%02d.wav",
-		currentTime.Day(),
-		currentTime.Month(),
-		currentTime.Year(),
-		currentTime.Hour(),
-		currentTime.Minute(),
-		currentTime.Second())
 
-	tempFilePath := filepath.Join(os.TempDir(), filename)
+	audioMeta, err := audioEncoder.Encode(tempFile, pcm, sampleRate, numChannels)
+	tempFile.Close()
+	if err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to encode audio: %w", err)
+	}
+	defer os.Remove(tempFilePath)
 
-	header := createWAVHeader(len(body))
+	encodedFile, err := os.Open(tempFilePath)
+	if err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to reopen temp file: %v", err)
+	}
+	defer encodedFile.Close()
 
-	// Write to temporary file
-	tempFile, err := os.Create(tempFilePath)
+	stat, err := encodedFile.Stat()
 	if err != nil {
-		log.Printf("Failed to create temp file: %v", err)
-		http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
-		return
+		return AudioFileMetadata{}, fmt.Errorf("failed to stat temp file: %v", err)
 	}
-	defer tempFile.Close()
 
-	// Write WAV header and audio data
-	tempFile.Write(header)
-	tempFile.Write(body)
+	meta := Metadata{FileName: name, ContentType: contentTypeForExt(audioEncoder.Extension()), Size: stat.Size()}
+	if _, err := storage.Put(ctx, name, encodedFile, meta); err != nil {
+		return AudioFileMetadata{}, fmt.Errorf("failed to save file: %v", err)
+	}
 
-	// Upload to AGFS if client is configured
-	if agfsClient != nil {
-		err = uploadToAGFS(tempFilePath, filename)
-		if err != nil {
-			log.Printf("Failed to upload to AGFS: %v", err)
-			// Fall back to local storage if AGFS upload fails
-			storageDir := os.Getenv("AUDIO_STORAGE_DIR")
-			if storageDir == "" {
-				storageDir = "./audio_files"
-			}
-			err = saveFileLocally(storageDir, filename, tempFilePath)
-			if err != nil {
-				log.Printf("Failed to save file locally: %v", err)
-				http.Error(w, "Failed to save file", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			log.Printf("File uploaded to AGFS successfully, skipping local storage")
-		}
-	} else {
-		// No AGFS configured, save to local storage
-		storageDir := os.Getenv("AUDIO_STORAGE_DIR")
-		if storageDir == "" {
-			storageDir = "./audio_files"
-		}
-		err = saveFileLocally(storageDir, filename, tempFilePath)
-		if err != nil {
-			log.Printf("Failed to save file locally: %v", err)
-			http.Error(w, "Failed to save file to local storage", http.StatusInternalServerError)
-			return
-		}
+	return audioMeta, nil
+}
+
+// cleanupRecording best-effort deletes a recording and its metadata
+// sidecar after it's discovered to be corrupt post-storage (e.g. a
+// Content-MD5 mismatch). Failures are logged, not returned: the request
+// has already failed, and there's no stronger guarantee to give the
+// caller about cleanup of a backend that may not support Delete at all.
+func cleanupRecording(ctx context.Context, filename string) {
+	baseName := strings.TrimSuffix(filename, "."+audioEncoder.Extension())
+	if err := storage.Delete(ctx, filename); err != nil {
+		log.Printf("Failed to clean up corrupt recording %s: %v", filename, err)
+	}
+	if err := storage.Delete(ctx, baseName+".json"); err != nil {
+		log.Printf("Failed to clean up metadata sidecar for %s: %v", filename, err)
 	}
+}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Audio bytes received and saved as %s", filename)))
+// writeMetadataSidecar stores the AudioFileMetadata JSON alongside the
+// encoded recording using the same storage backend.
+func writeMetadataSidecar(ctx context.Context, name string, meta AudioFileMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %v", err)
+	}
+
+	_, err = storage.Put(ctx, name, bytes.NewReader(data), Metadata{
+		FileName:    name,
+		ContentType: "application/json",
+		Size:        int64(len(data)),
+	})
+	return err
 }
 
 func main() {
 	// Define command line flags
 	addr := flag.String("addr", "", "Server address (default: :8080)")
+	configPath := flag.String("config", "", "Path to a JSON storage config file")
+	storageOrder := flag.String("storage", "", "Comma-separated storage backend chain, in priority order (local,agfs,s3)")
+	localDir := flag.String("local-dir", "", "Local storage directory (default: ./audio_files)")
 	agfsAPIURL := flag.String("agfs-api-url", "", "AGFS client API URL")
 	agfsPath := flag.String("agfs-upload-path", "", "AGFS upload path (e.g., /s3fs/aws/dongxu/omi-recording/)")
+	s3Endpoint := flag.String("s3-endpoint", "", "S3/MinIO endpoint URL")
+	s3Region := flag.String("s3-region", "", "S3 region (default: us-east-1)")
+	s3Bucket := flag.String("s3-bucket", "", "S3 bucket name")
+	s3ACL := flag.String("s3-acl", "", "S3 object ACL (e.g., public-read)")
+	s3PathStyle := flag.Bool("s3-path-style", false, "Use path-style S3 addressing (required by most MinIO setups)")
+	s3ServingEndpoint := flag.String("s3-serving-endpoint", "", "Public endpoint to serve uploaded files from, if different from s3-endpoint")
+	encode := flag.String("encode", "wav", "Audio encoding for stored recordings: wav, mp3, or opus")
+	tusUploadDir := flag.String("tus-upload-dir", "", "Directory for in-progress tus uploads (default: <tmpdir>/omi-tus-uploads)")
+	sessionIdleWindow := flag.Duration("session-idle-window", defaultSessionIdleWindow, "How long to wait for another chunk before starting a new session for a uid")
+	maxUploadBytesFlag := flag.Int64("max-upload-bytes", defaultMaxUploadBytes, "Maximum accepted size, in bytes, of a single /audio request body")
+	authConfigPath := flag.String("auth-config", "", "Path to a JSON API key config file; enables authentication")
+	authProviderURL := flag.String("auth-provider-url", "", "URL of an auth provider returning the same JSON shape as -auth-config")
 	flag.Parse()
 
+	maxUploadBytes = *maxUploadBytesFlag
+
 	// Get address from environment variable or command line flag
 	serverAddr := os.Getenv("SERVER_ADDR")
 	if *addr != "" {
@@ -200,19 +265,105 @@ func main() {
 		serverAddr = ":8080"
 	}
 
-	// Initialize AGFS client if API URL is provided
+	var cfg StorageConfig
+	if *configPath != "" {
+		loaded, err := loadStorageConfig(*configPath)
+		if err != nil {
+			log.Fatalf("Failed to load storage config: %v", err)
+		}
+		cfg = *loaded
+		log.Printf("Loaded storage config from %s", *configPath)
+	}
+
+	// Command line flags take precedence over the config file.
+	if *storageOrder != "" {
+		cfg.Order = strings.Split(*storageOrder, ",")
+	}
+	if *localDir != "" {
+		cfg.LocalDir = *localDir
+	}
 	if *agfsAPIURL != "" {
-		agfsClient = agfs.NewClient(*agfsAPIURL)
-		agfsUploadPath = *agfsPath
-		log.Printf("AGFS client initialized with API URL: %s", *agfsAPIURL)
-		if agfsUploadPath != "" {
-			log.Printf("AGFS upload path: %s", agfsUploadPath)
+		cfg.AGFSAPIURL = *agfsAPIURL
+	}
+	if *agfsPath != "" {
+		cfg.AGFSUploadDir = *agfsPath
+	}
+	if *s3Endpoint != "" {
+		cfg.S3.Endpoint = *s3Endpoint
+	}
+	if *s3Region != "" {
+		cfg.S3.Region = *s3Region
+	}
+	if *s3Bucket != "" {
+		cfg.S3.Bucket = *s3Bucket
+	}
+	if *s3ACL != "" {
+		cfg.S3.ACL = *s3ACL
+	}
+	if *s3PathStyle {
+		cfg.S3.PathStyle = true
+	}
+	if *s3ServingEndpoint != "" {
+		cfg.S3.ServingEndpoint = *s3ServingEndpoint
+	}
+	if cfg.S3.Region == "" {
+		cfg.S3.Region = "us-east-1"
+	}
+
+	if len(cfg.Order) == 0 {
+		// Preserve the old default behavior: ship to AGFS if configured,
+		// otherwise fall back to local disk.
+		if cfg.AGFSAPIURL != "" {
+			cfg.Order = []string{"agfs", "local"}
+		} else {
+			cfg.Order = []string{"local"}
 		}
-	} else {
-		log.Printf("AGFS API URL not provided, files will only be saved locally")
 	}
 
-	http.HandleFunc("/audio", handlePostAudio)
+	var err error
+	storage, err = buildStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	log.Printf("Storage backend chain: %v", cfg.Order)
+
+	audioEncoder, err = buildEncoder(*encode)
+	if err != nil {
+		log.Fatalf("Failed to initialize encoder: %v", err)
+	}
+	log.Printf("Audio encoding: %s", audioEncoder.Extension())
+
+	uploadDir := *tusUploadDir
+	if uploadDir == "" {
+		uploadDir = filepath.Join(os.TempDir(), "omi-tus-uploads")
+	}
+	tusUploads = newTusStore(uploadDir)
+
+	sessions = newSessionManager(*sessionIdleWindow)
+
+	switch {
+	case *authProviderURL != "":
+		authCfg, err := fetchAuthConfig(*authProviderURL)
+		if err != nil {
+			log.Fatalf("Failed to fetch auth config: %v", err)
+		}
+		auth = newAuthProvider(*authCfg)
+		log.Printf("Authentication enabled via provider %s (%d keys)", *authProviderURL, len(authCfg.Keys))
+	case *authConfigPath != "":
+		authCfg, err := loadAuthConfig(*authConfigPath)
+		if err != nil {
+			log.Fatalf("Failed to load auth config: %v", err)
+		}
+		auth = newAuthProvider(*authCfg)
+		log.Printf("Authentication enabled via %s (%d keys)", *authConfigPath, len(authCfg.Keys))
+	default:
+		log.Printf("No auth config provided, /audio and friends are open")
+	}
+
+	http.HandleFunc("/audio", requireAuth(handlePostAudio))
+	http.HandleFunc("/files/", requireAuth(tusHandler))
+	http.HandleFunc("/sessions/", requireAuth(sessionsHandler))
+	http.HandleFunc("/admin/keys", requireAdmin(adminKeysHandler))
 	log.Printf("Server starting on %s...", serverAddr)
 	log.Fatal(http.ListenAndServe(serverAddr, nil))
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// oggOpusWriter writes Opus packets into a minimal single-stream Ogg
+// container, following RFC 7845 (Ogg encapsulation for Opus). It only
+// implements what's needed to produce a file players can decode: an
+// OpusHead page, an OpusTags page, and one packet per audio page.
+type oggOpusWriter struct {
+	w             io.Writer
+	sampleRate    int
+	channels      int
+	serial        uint32
+	pageSeq       uint32
+	granulePos    uint64
+	headerWritten bool
+}
+
+func newOggOpusWriter(w io.Writer, sampleRate, channels int) *oggOpusWriter {
+	return &oggOpusWriter{w: w, sampleRate: sampleRate, channels: channels, serial: 1}
+}
+
+// WriteHeaders emits the mandatory OpusHead and OpusTags pages that must
+// precede any audio data in an Ogg Opus stream.
+func (o *oggOpusWriter) WriteHeaders() error {
+	head := make([]byte, 19)
+	copy(head[0:8], []byte("OpusHead"))
+	head[8] = 1 // version
+	head[9] = byte(o.channels)
+	binary.LittleEndian.PutUint16(head[10:12], 0) // pre-skip
+	binary.LittleEndian.PutUint32(head[12:16], uint32(o.sampleRate))
+	binary.LittleEndian.PutUint16(head[16:18], 0) // output gain
+	head[18] = 0                                  // channel mapping family
+	if err := o.writePage(head, 0, true, false); err != nil {
+		return err
+	}
+
+	tags := make([]byte, 0, 28)
+	tags = append(tags, []byte("OpusTags")...)
+	vendor := []byte("omi-audio-streaming")
+	tags = append(tags, uint32le(uint32(len(vendor)))...)
+	tags = append(tags, vendor...)
+	tags = append(tags, uint32le(0)...) // no user comments
+	return o.writePage(tags, 0, false, false)
+}
+
+// WritePacket writes a single encoded Opus packet as its own Ogg page.
+// Every packet is counted as opusFrameSamples samples (per channel) when
+// advancing the stream's granule position.
+func (o *oggOpusWriter) WritePacket(packet []byte) error {
+	o.granulePos += uint64(opusFrameSamples)
+	return o.writePage(packet, o.granulePos, false, false)
+}
+
+func (o *oggOpusWriter) Close() error {
+	// Mark the end of stream by emitting a final empty page with the EOS
+	// flag set.
+	return o.writePage(nil, o.granulePos, false, true)
+}
+
+func (o *oggOpusWriter) writePage(packet []byte, granulePos uint64, first, last bool) error {
+	segments, lacing := segmentTable(packet)
+
+	header := make([]byte, 27)
+	copy(header[0:4], []byte("OggS"))
+	header[4] = 0 // stream structure version
+	var flags byte
+	if first {
+		flags |= 0x02
+	}
+	if last {
+		flags |= 0x04
+	}
+	header[5] = flags
+	binary.LittleEndian.PutUint64(header[6:14], granulePos)
+	binary.LittleEndian.PutUint32(header[14:18], o.serial)
+	binary.LittleEndian.PutUint32(header[18:22], o.pageSeq)
+	o.pageSeq++
+	// CRC (header[22:26]) filled in below.
+	header[26] = byte(segments)
+
+	page := append(header, lacing...)
+	page = append(page, packet...)
+
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC32(page))
+
+	_, err := o.w.Write(page)
+	return err
+}
+
+// segmentTable builds the Ogg lacing values for a packet, splitting it
+// into 255-byte segments as required by the spec.
+func segmentTable(packet []byte) (count int, lacing []byte) {
+	n := len(packet)
+	full := n / 255
+	rem := n % 255
+	lacing = make([]byte, 0, full+1)
+	for i := 0; i < full; i++ {
+		lacing = append(lacing, 255)
+	}
+	lacing = append(lacing, byte(rem))
+	return len(lacing), lacing
+}
+
+func uint32le(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+// oggCRC32 computes the CRC used by the Ogg container format: the CRC-32
+// polynomial applied unreflected, with a zero initial value and no final
+// XOR, unlike the standard (reflected) CRC-32.
+func oggCRC32(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = (crc << 8) ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	const poly = 0x04c11db7
+	for i := 0; i < 256; i++ {
+		crc := uint32(i) << 24
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x80000000 != 0 {
+				crc = (crc << 1) ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// bytesToPCM16 reinterprets a little-endian PCM16 byte slice as int16
+// samples for the Opus encoder.
+func bytesToPCM16(b []byte) []int16 {
+	samples := make([]int16, len(b)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(b[i*2 : i*2+2]))
+	}
+	return samples
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSessionIdleWindow is how long we wait without a new chunk before
+// treating the next one as the start of a new session, per uid.
+const defaultSessionIdleWindow = 30 * time.Second
+
+// sessionSegment is one chunk's worth of encoded audio within a session's
+// rolling playlist.
+type sessionSegment struct {
+	filename   string
+	durationMs int64
+}
+
+// audioSession assembles the chunks an Omi device streams for a single
+// wearable session (keyed by uid) into an HLS-style rolling playlist:
+// one segment file per chunk plus a .m3u8 index, both written to the
+// configured storage backend as chunks arrive.
+type audioSession struct {
+	mu          sync.Mutex
+	uid         string
+	id          string
+	startTime   time.Time
+	lastChunk   time.Time
+	segments    []sessionSegment
+	playlistURL string
+}
+
+// sessionRetentionFactor sets how long a session is kept in memory after
+// its last chunk before it's evicted, as a multiple of the idle window: long enough
+// that a GET /sessions/{uid} shortly after a recording ends still works,
+// short enough that a uid that never reconnects doesn't live forever.
+const sessionRetentionFactor = 10
+
+// sessionManager tracks the current session for each uid and decides,
+// based on an idle window, whether an incoming chunk continues that
+// session or starts a new one. Sessions idle for longer than the
+// retention window are evicted so a fleet of devices that connect once
+// and never return doesn't grow the sessions map without bound.
+type sessionManager struct {
+	mu         sync.Mutex
+	sessions   map[string]*audioSession
+	idleWindow time.Duration
+}
+
+func newSessionManager(idleWindow time.Duration) *sessionManager {
+	if idleWindow <= 0 {
+		idleWindow = defaultSessionIdleWindow
+	}
+	m := &sessionManager{sessions: make(map[string]*audioSession), idleWindow: idleWindow}
+	go m.reapLoop()
+	return m
+}
+
+// reapLoop periodically evicts sessions that have been idle for longer
+// than the retention window. It never returns.
+func (m *sessionManager) reapLoop() {
+	ticker := time.NewTicker(m.idleWindow)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.reapExpired(time.Now())
+	}
+}
+
+// reapExpired removes every session whose last chunk is older than the
+// retention window as of now.
+func (m *sessionManager) reapExpired(now time.Time) {
+	cutoff := now.Add(-m.idleWindow * sessionRetentionFactor)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for uid, s := range m.sessions {
+		s.mu.Lock()
+		last := s.lastChunk
+		s.mu.Unlock()
+		if last.Before(cutoff) {
+			delete(m.sessions, uid)
+		}
+	}
+}
+
+// getOrCreate returns the session a chunk for uid belongs to, starting a
+// new one if there isn't one yet or the idle window has elapsed.
+func (m *sessionManager) getOrCreate(uid string) *audioSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	s, ok := m.sessions[uid]
+	if !ok || now.Sub(s.lastChunk) > m.idleWindow {
+		s = &audioSession{
+			uid:       uid,
+			id:        fmt.Sprintf("%s_%d", uid, now.Unix()),
+			startTime: now,
+			lastChunk: now,
+		}
+		m.sessions[uid] = s
+	}
+	return s
+}
+
+// get returns the current session for uid, if any, without affecting its
+// idle window.
+func (m *sessionManager) get(uid string) (*audioSession, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[uid]
+	return s, ok
+}
+
+// addChunk encodes and stores one chunk as the next segment of uid's
+// current session, then rewrites and re-uploads the session's playlist.
+func (m *sessionManager) addChunk(ctx context.Context, uid string, pcm io.Reader) error {
+	s := m.getOrCreate(uid)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segIndex := len(s.segments)
+	segName := fmt.Sprintf("session_%s_%04d.%s", s.id, segIndex, audioEncoder.Extension())
+
+	audioMeta, err := encodeAndStore(ctx, segName, pcm)
+	if err != nil {
+		return fmt.Errorf("failed to store session segment: %w", err)
+	}
+
+	s.segments = append(s.segments, sessionSegment{filename: segName, durationMs: audioMeta.DurationMs})
+	s.lastChunk = time.Now()
+
+	playlistName := fmt.Sprintf("session_%s.m3u8", s.uid)
+	playlist := buildM3U8(s.segments)
+	url, err := storage.Put(ctx, playlistName, strings.NewReader(playlist), Metadata{
+		FileName:    playlistName,
+		ContentType: "application/vnd.apple.mpegurl",
+		Size:        int64(len(playlist)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store session playlist: %v", err)
+	}
+	s.playlistURL = url
+
+	return nil
+}
+
+// buildM3U8 renders an HLS media playlist listing every segment recorded
+// so far for a session. No chunk is ever known to be a session's last
+// (a session is only superseded when a chunk arrives after the idle
+// window), so the playlist never gets #EXT-X-ENDLIST and is always marked
+// EVENT rather than VOD.
+func buildM3U8(segments []sessionSegment) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString("#EXT-X-TARGETDURATION:60\n")
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	for _, seg := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", float64(seg.durationMs)/1000)
+		b.WriteString(seg.filename + "\n")
+	}
+	return b.String()
+}
+
+// sessionSummary is the JSON payload returned by GET /sessions/{uid}.
+type sessionSummary struct {
+	UID          string    `json:"uid"`
+	PlaylistURL  string    `json:"playlist_url"`
+	StartTime    time.Time `json:"start_time"`
+	DurationMs   int64     `json:"duration_ms"`
+	SegmentCount int       `json:"segment_count"`
+}
+
+func (s *audioSession) summary() sessionSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.durationMs
+	}
+
+	return sessionSummary{
+		UID:          s.uid,
+		PlaylistURL:  s.playlistURL,
+		StartTime:    s.startTime,
+		DurationMs:   total,
+		SegmentCount: len(s.segments),
+	}
+}
+
+var sessions *sessionManager
+
+// sessionsHandler serves GET /sessions/{uid} with the session's current
+// playlist URL and a JSON summary.
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uid := strings.TrimPrefix(r.URL.Path, "/sessions/")
+	if uid == "" {
+		http.Error(w, "uid is required", http.StatusBadRequest)
+		return
+	}
+	if authedUID, ok := authUID(r); ok && authedUID != uid {
+		http.Error(w, "API key does not match uid", http.StatusForbidden)
+		return
+	}
+
+	s, ok := sessions.get(uid)
+	if !ok {
+		http.Error(w, "no session for uid", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.summary())
+}
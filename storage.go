@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// Metadata describes the object being written, independent of the backend
+// that ends up storing it.
+type Metadata struct {
+	FileName    string
+	ContentType string
+	Size        int64
+}
+
+// Storage is implemented by every audio storage backend. Put writes r to
+// the given path and returns a URL (or local path) the object can later be
+// fetched from. Delete removes a previously stored object, best-effort:
+// callers use it to clean up after the fact (e.g. a failed integrity
+// check), not as a guarantee.
+type Storage interface {
+	Put(ctx context.Context, path string, r io.Reader, meta Metadata) (url string, err error)
+	Delete(ctx context.Context, path string) error
+}
+
+// LocalStorage writes files to a directory on the local filesystem.
+type LocalStorage struct {
+	Dir string
+}
+
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{Dir: dir}
+}
+
+func (s *LocalStorage) Put(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create storage directory: %v", err)
+	}
+
+	destPath := filepath.Join(s.Dir, path)
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create destination file: %v", err)
+	}
+	defer destFile.Close()
+
+	if _, err := io.Copy(destFile, r); err != nil {
+		return "", fmt.Errorf("failed to copy file: %v", err)
+	}
+
+	log.Printf("File %s saved to local storage directory %s successfully.", path, s.Dir)
+	return destPath, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, path string) error {
+	if err := os.Remove(filepath.Join(s.Dir, path)); err != nil {
+		return fmt.Errorf("failed to delete local file: %v", err)
+	}
+	return nil
+}
+
+// AGFSStorage uploads files through the AGFS client SDK.
+type AGFSStorage struct {
+	client     *agfs.Client
+	uploadPath string
+}
+
+func NewAGFSStorage(apiURL, uploadPath string) *AGFSStorage {
+	return &AGFSStorage{
+		client:     agfs.NewClient(apiURL),
+		uploadPath: uploadPath,
+	}
+}
+
+func (s *AGFSStorage) Put(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+	if s.client == nil {
+		return "", fmt.Errorf("AGFS client not initialized")
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+
+	fullPath := path
+	if s.uploadPath != "" {
+		fullPath = filepath.Join(s.uploadPath, path)
+	}
+
+	if _, err := s.client.Write(fullPath, data); err != nil {
+		return "", fmt.Errorf("failed to upload to AGFS: %v", err)
+	}
+
+	log.Printf("File uploaded to AGFS at path: %s", fullPath)
+	return fullPath, nil
+}
+
+func (s *AGFSStorage) Delete(ctx context.Context, path string) error {
+	// The AGFS client SDK doesn't expose a delete call; nothing to do but
+	// report that cleanup can't happen here.
+	return fmt.Errorf("AGFS storage backend does not support delete")
+}
+
+// S3Config holds the settings needed to talk to an S3-compatible endpoint
+// (AWS S3, MinIO, etc).
+type S3Config struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	ACL             string `json:"acl"`
+	PathStyle       bool   `json:"path_style"`
+	// ServingEndpoint, if set, is used to build the public URL returned from
+	// Put instead of the upload endpoint (e.g. a CDN in front of the bucket).
+	ServingEndpoint string `json:"serving_endpoint"`
+}
+
+// S3Storage uploads files to an S3 or MinIO bucket using the AWS SDK's
+// managed multipart uploader.
+type S3Storage struct {
+	uploader *s3manager.Uploader
+	cfg      S3Config
+}
+
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage: bucket is required")
+	}
+
+	awsCfg := aws.NewConfig().
+		WithRegion(cfg.Region).
+		WithS3ForcePathStyle(cfg.PathStyle)
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+	if cfg.AccessKeyID != "" {
+		awsCfg = awsCfg.WithCredentials(credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, ""))
+	}
+
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 session: %v", err)
+	}
+
+	return &S3Storage{
+		uploader: s3manager.NewUploader(sess),
+		cfg:      cfg,
+	}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(path),
+		Body:   r,
+	}
+	if s.cfg.ACL != "" {
+		input.ACL = aws.String(s.cfg.ACL)
+	}
+	if meta.ContentType != "" {
+		input.ContentType = aws.String(meta.ContentType)
+	}
+
+	out, err := s.uploader.UploadWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to S3: %v", err)
+	}
+
+	url := out.Location
+	if s.cfg.ServingEndpoint != "" {
+		url = strings.TrimRight(s.cfg.ServingEndpoint, "/") + "/" + path
+	}
+
+	log.Printf("File uploaded to S3 bucket %s at key: %s", s.cfg.Bucket, path)
+	return url, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, path string) error {
+	_, err := s.uploader.S3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(path),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete S3 object: %v", err)
+	}
+	return nil
+}
+
+// ChainStorage writes to a primary backend and falls back to the next one
+// in the chain if the previous write fails. It replaces the old hard-coded
+// "AGFS then local fallback" branching in handlePostAudio.
+type ChainStorage struct {
+	backends []Storage
+}
+
+func NewChainStorage(backends ...Storage) *ChainStorage {
+	return &ChainStorage{backends: backends}
+}
+
+func (c *ChainStorage) Put(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+	if len(c.backends) == 0 {
+		return "", fmt.Errorf("chain storage: no backends configured")
+	}
+
+	// The common case is the first backend succeeding, so stream straight
+	// into it without buffering. A spool file mirrors whatever it reads,
+	// so if it fails partway we can still reconstruct the full upload for
+	// the remaining backends without having buffered anything up front.
+	spool, err := os.CreateTemp("", "chainstorage-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create chain storage spool file: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	url, err := c.backends[0].Put(ctx, path, io.TeeReader(r, spool), meta)
+	if err == nil {
+		return url, nil
+	}
+	log.Printf("storage backend 1/%d failed, trying next: %v", len(c.backends), err)
+
+	if len(c.backends) == 1 {
+		return "", fmt.Errorf("all storage backends failed: %v", err)
+	}
+
+	// Reconstruct the upload: bytes already consumed live in the spool
+	// file, whatever's left is still sitting in r. Only now, on the
+	// fallback path, do we buffer it so every remaining backend can read
+	// it independently.
+	if _, serr := spool.Seek(0, io.SeekStart); serr != nil {
+		return "", fmt.Errorf("all storage backends failed: %v (and failed to rewind spool: %v)", err, serr)
+	}
+	data, rerr := io.ReadAll(io.MultiReader(spool, r))
+	if rerr != nil {
+		return "", fmt.Errorf("all storage backends failed: %v (and failed to buffer for fallback: %v)", err, rerr)
+	}
+
+	lastErr := err
+	for i := 1; i < len(c.backends); i++ {
+		url, err := c.backends[i].Put(ctx, path, bytes.NewReader(data), meta)
+		if err == nil {
+			return url, nil
+		}
+		log.Printf("storage backend %d/%d failed, trying next: %v", i+1, len(c.backends), err)
+		lastErr = err
+	}
+
+	return "", fmt.Errorf("all storage backends failed: %v", lastErr)
+}
+
+// Delete removes path from every backend in the chain, best-effort: it
+// tries them all rather than stopping at the first error, since the
+// object returned by Put may have ended up on more than one backend.
+func (c *ChainStorage) Delete(ctx context.Context, path string) error {
+	var lastErr error
+	for i, backend := range c.backends {
+		if err := backend.Delete(ctx, path); err != nil {
+			log.Printf("storage backend %d/%d failed to delete %s: %v", i+1, len(c.backends), path, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
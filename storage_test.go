@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// fakeStorage is a minimal Storage backend whose Put/Delete behavior is
+// supplied per-test.
+type fakeStorage struct {
+	put    func(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error)
+	called bool
+}
+
+func (f *fakeStorage) Put(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+	f.called = true
+	return f.put(ctx, path, r, meta)
+}
+
+func (f *fakeStorage) Delete(ctx context.Context, path string) error { return nil }
+
+func TestChainStoragePutUsesFirstBackendWithoutBuffering(t *testing.T) {
+	original := []byte("the quick brown fox jumps over the lazy dog")
+	var received []byte
+
+	first := &fakeStorage{put: func(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		received = data
+		return "first-url", nil
+	}}
+	second := &fakeStorage{put: func(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+		t.Fatalf("second backend should not be used when the first succeeds")
+		return "", nil
+	}}
+
+	chain := NewChainStorage(first, second)
+	url, err := chain.Put(context.Background(), "path", bytes.NewReader(original), Metadata{})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if url != "first-url" {
+		t.Fatalf("url = %q, want %q", url, "first-url")
+	}
+	if !bytes.Equal(received, original) {
+		t.Fatalf("first backend got %q, want %q", received, original)
+	}
+}
+
+func TestChainStoragePutFallsBackAfterPartialRead(t *testing.T) {
+	original := []byte("the quick brown fox jumps over the lazy dog")
+	var received []byte
+
+	first := &fakeStorage{put: func(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+		// Read only part of the body before failing, the way a mid-stream
+		// network error would, to exercise the spool-and-reconstruct path
+		// rather than a failure before any bytes are read.
+		partial := make([]byte, 10)
+		if _, err := io.ReadFull(r, partial); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("simulated failure after partial read")
+	}}
+	second := &fakeStorage{put: func(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		received = data
+		return "second-url", nil
+	}}
+
+	chain := NewChainStorage(first, second)
+	url, err := chain.Put(context.Background(), "path", bytes.NewReader(original), Metadata{})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if url != "second-url" {
+		t.Fatalf("url = %q, want %q", url, "second-url")
+	}
+	if !bytes.Equal(received, original) {
+		t.Fatalf("second backend got %q, want the exact original bytes %q", received, original)
+	}
+}
+
+func TestChainStoragePutReturnsErrorWhenAllBackendsFail(t *testing.T) {
+	fail := &fakeStorage{put: func(ctx context.Context, path string, r io.Reader, meta Metadata) (string, error) {
+		io.ReadAll(r)
+		return "", fmt.Errorf("backend down")
+	}}
+
+	chain := NewChainStorage(fail, fail)
+	if _, err := chain.Put(context.Background(), "path", bytes.NewReader([]byte("data")), Metadata{}); err == nil {
+		t.Fatalf("expected an error when every backend fails")
+	}
+}
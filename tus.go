@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const tusResumableVersion = "1.0.0"
+
+// tusUpload tracks the state of one in-progress resumable upload.
+type tusUpload struct {
+	mu       sync.Mutex
+	id       string
+	uid      string
+	length   int64
+	offset   int64
+	tempPath string
+}
+
+// tusStore keeps in-progress uploads keyed by upload ID, each backed by a
+// temp file on disk so a flaky Omi device can resume a recording instead
+// of restarting it from byte zero.
+type tusStore struct {
+	mu      sync.Mutex
+	uploads map[string]*tusUpload
+	dir     string
+}
+
+func newTusStore(dir string) *tusStore {
+	return &tusStore{uploads: make(map[string]*tusUpload), dir: dir}
+}
+
+func (s *tusStore) create(uid string, length int64) (*tusUpload, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create tus upload directory: %v", err)
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload id: %v", err)
+	}
+
+	tempPath := filepath.Join(s.dir, id+".pcm")
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload temp file: %v", err)
+	}
+	f.Close()
+
+	upload := &tusUpload{id: id, uid: uid, length: length, tempPath: tempPath}
+
+	s.mu.Lock()
+	s.uploads[id] = upload
+	s.mu.Unlock()
+
+	return upload, nil
+}
+
+func (s *tusStore) get(id string) (*tusUpload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	return u, ok
+}
+
+func (s *tusStore) remove(id string) {
+	s.mu.Lock()
+	delete(s.uploads, id)
+	s.mu.Unlock()
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+var tusUploads *tusStore
+
+// tusHandler implements the subset of the tus.io resumable upload
+// protocol needed by an Omi device on a flaky connection: creation,
+// offset continuation, and status checks. On the PATCH that completes an
+// upload it runs the recording through the existing encode + storage
+// pipeline.
+func tusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+
+	id := strings.TrimPrefix(r.URL.Path, "/files/")
+
+	switch r.Method {
+	case http.MethodPost:
+		if id != "" {
+			http.Error(w, "upload id must not be set on creation", http.StatusBadRequest)
+			return
+		}
+		tusCreate(w, r)
+	case http.MethodHead:
+		tusHead(w, r, id)
+	case http.MethodPatch:
+		tusPatch(w, r, id)
+	case http.MethodOptions:
+		w.Header().Set("Tus-Version", tusResumableVersion)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func tusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > maxUploadBytes {
+		http.Error(w, "Upload-Length exceeds max-upload-bytes limit", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Pin the upload to the authenticated caller when auth is enabled, so
+	// it can't be created owner-less (the tus protocol doesn't require a
+	// ?uid= query param) and then permanently rejected by the ownership
+	// checks in tusHead/tusPatch. Only fall back to the query param when
+	// auth is disabled, matching how /audio and /sessions behave open.
+	uid := r.URL.Query().Get("uid")
+	if authedUID, ok := authUID(r); ok {
+		uid = authedUID
+	}
+
+	upload, err := tusUploads.create(uid, length)
+	if err != nil {
+		log.Printf("Failed to create tus upload: %v", err)
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Created tus upload %s for uid %s (length=%d)", upload.id, uid, length)
+
+	w.Header().Set("Location", "/files/"+upload.id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func tusHead(w http.ResponseWriter, r *http.Request, id string) {
+	upload, ok := tusUploads.get(id)
+	if !ok {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	if authedUID, ok := authUID(r); ok && authedUID != upload.uid {
+		http.Error(w, "API key does not match upload owner", http.StatusForbidden)
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func tusPatch(w http.ResponseWriter, r *http.Request, id string) {
+	upload, ok := tusUploads.get(id)
+	if !ok {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	if authedUID, ok := authUID(r); ok && authedUID != upload.uid {
+		http.Error(w, "API key does not match upload owner", http.StatusForbidden)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "missing or invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	if offset != upload.offset {
+		http.Error(w, "Upload-Offset does not match current offset", http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(upload.tempPath, os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Failed to open upload temp file: %v", err)
+		http.Error(w, "failed to continue upload", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("Failed to seek upload temp file: %v", err)
+		http.Error(w, "failed to continue upload", http.StatusInternalServerError)
+		return
+	}
+
+	// Bound this chunk the same way handlePostAudio bounds /audio: a
+	// client can't grow an upload past the length it declared on create,
+	// which in turn is already capped at maxUploadBytes.
+	limited := http.MaxBytesReader(w, r.Body, upload.length-upload.offset)
+	written, err := io.Copy(f, limited)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "chunk exceeds the upload's remaining Upload-Length", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Failed to write upload chunk: %v", err)
+		http.Error(w, "failed to write upload chunk", http.StatusInternalServerError)
+		return
+	}
+	upload.offset += written
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+
+	if upload.offset < upload.length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Upload is complete: run the finished recording through the normal
+	// encode + storage pipeline.
+	pcm, err := os.Open(upload.tempPath)
+	if err != nil {
+		log.Printf("Failed to open completed upload: %v", err)
+		http.Error(w, "failed to finalize upload", http.StatusInternalServerError)
+		return
+	}
+
+	filename, err := processRecording(r.Context(), pcm)
+	pcm.Close()
+	if err != nil {
+		log.Printf("Failed to process tus upload %s: %v", upload.id, err)
+		http.Error(w, "failed to finalize upload", http.StatusInternalServerError)
+		return
+	}
+
+	os.Remove(upload.tempPath)
+	tusUploads.remove(upload.id)
+
+	log.Printf("Completed tus upload %s for uid %s as %s", upload.id, upload.uid, filename)
+	w.WriteHeader(http.StatusNoContent)
+}
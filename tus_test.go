@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestTusUpload(t *testing.T, length int64) *tusUpload {
+	t.Helper()
+	tusUploads = newTusStore(t.TempDir())
+	upload, err := tusUploads.create("uid1", length)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	return upload
+}
+
+func patchRequest(id string, offset int64, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPatch, "/files/"+id, strings.NewReader(body))
+	r.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	return r
+}
+
+func TestTusCreateRejectsOversizedUpload(t *testing.T) {
+	tusUploads = newTusStore(t.TempDir())
+	origMax := maxUploadBytes
+	maxUploadBytes = 100
+	defer func() { maxUploadBytes = origMax }()
+
+	r := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	r.Header.Set("Upload-Length", "200")
+	w := httptest.NewRecorder()
+
+	tusHandler(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestTusCreateAllowsUploadWithinLimit(t *testing.T) {
+	tusUploads = newTusStore(t.TempDir())
+	origMax := maxUploadBytes
+	maxUploadBytes = 100
+	defer func() { maxUploadBytes = origMax }()
+
+	r := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	r.Header.Set("Upload-Length", "50")
+	w := httptest.NewRecorder()
+
+	tusHandler(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if loc := w.Header().Get("Location"); loc == "" {
+		t.Fatalf("expected a Location header on creation")
+	}
+}
+
+func TestTusPatchOffsetMismatchReturnsConflict(t *testing.T) {
+	upload := newTestTusUpload(t, 10)
+
+	w := httptest.NewRecorder()
+	tusPatch(w, patchRequest(upload.id, 5, "hello"), upload.id)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestTusPatchAdvancesOffset(t *testing.T) {
+	upload := newTestTusUpload(t, 10)
+
+	w := httptest.NewRecorder()
+	tusPatch(w, patchRequest(upload.id, 0, "hello"), upload.id)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "5" {
+		t.Fatalf("Upload-Offset header = %q, want %q", got, "5")
+	}
+	if upload.offset != 5 {
+		t.Fatalf("upload.offset = %d, want 5", upload.offset)
+	}
+}
+
+func TestTusPatchRejectsChunkPastDeclaredLength(t *testing.T) {
+	upload := newTestTusUpload(t, 5)
+
+	w := httptest.NewRecorder()
+	tusPatch(w, patchRequest(upload.id, 0, "too many bytes for this upload"), upload.id)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestTusHeadReportsOffsetAndLength(t *testing.T) {
+	upload := newTestTusUpload(t, 10)
+	upload.offset = 4
+
+	w := httptest.NewRecorder()
+	tusHead(w, httptest.NewRequest(http.MethodHead, "/files/"+upload.id, nil), upload.id)
+
+	if got := w.Header().Get("Upload-Offset"); got != "4" {
+		t.Fatalf("Upload-Offset = %q, want %q", got, "4")
+	}
+	if got := w.Header().Get("Upload-Length"); got != "10" {
+		t.Fatalf("Upload-Length = %q, want %q", got, "10")
+	}
+}
+
+func TestTusCreatePinsOwnerToAuthenticatedUID(t *testing.T) {
+	origAuth := auth
+	defer func() { auth = origAuth }()
+	auth = newAuthProvider(AuthConfig{Keys: []APIKeyConfig{
+		{UID: "alice", Key: "alice-key", BytesPerDay: 1 << 30, RequestsPerMinute: 1000},
+	}})
+
+	tusUploads = newTusStore(t.TempDir())
+	origMax := maxUploadBytes
+	maxUploadBytes = 1 << 20
+	defer func() { maxUploadBytes = origMax }()
+
+	handler := requireAuth(tusHandler)
+
+	// Create without a ?uid= query param, as the tus protocol allows.
+	createReq := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	createReq.Header.Set("Upload-Length", "10") // larger than the patch below, so it stays in progress
+	createReq.Header.Set("Authorization", "Bearer alice-key")
+	createW := httptest.NewRecorder()
+	handler(createW, createReq)
+
+	if createW.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d", createW.Code, http.StatusCreated)
+	}
+	id := strings.TrimPrefix(createW.Header().Get("Location"), "/files/")
+
+	upload, ok := tusUploads.get(id)
+	if !ok {
+		t.Fatalf("upload %s not found after create", id)
+	}
+	if upload.uid != "alice" {
+		t.Fatalf("upload.uid = %q, want %q (the authenticated caller, not an empty ?uid=)", upload.uid, "alice")
+	}
+
+	// The same device then patches its own upload with the same bearer
+	// token; this must not 403 against an owner-less upload.
+	patchReq := patchRequest(id, 0, "hello")
+	patchReq.Header.Set("Authorization", "Bearer alice-key")
+	patchW := httptest.NewRecorder()
+	handler(patchW, patchReq)
+
+	if patchW.Code != http.StatusNoContent {
+		t.Fatalf("patch status = %d, want %d", patchW.Code, http.StatusNoContent)
+	}
+}
+
+func TestTusHeadUnknownUploadReturnsNotFound(t *testing.T) {
+	tusUploads = newTusStore(t.TempDir())
+
+	w := httptest.NewRecorder()
+	tusHead(w, httptest.NewRequest(http.MethodHead, "/files/missing", nil), "missing")
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}